@@ -0,0 +1,313 @@
+// Copyright 2019 The Hugo Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hugofs
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// RootMapping describes a virtual mount of a real directory (To, resolved
+// against the Fs passed to NewRootMappingFs) at a virtual path (From), e.g.
+// mounting "themes/a/content" at "content/". Several mappings may share the
+// same From, e.g. one per language or one per theme component providing
+// content.
+type RootMapping struct {
+	From string // Virtual mount point, e.g. "content/blog".
+	To   string // Real path, relative to the Fs given to NewRootMappingFs.
+	Meta FileMeta
+
+	// Include, if not empty, only includes files matching one of these
+	// doublestar glob patterns, evaluated against the path relative to To.
+	Include []string
+
+	// Exclude excludes files matching one of these doublestar glob patterns,
+	// evaluated against the path relative to To. Exclude is applied after
+	// Include.
+	Exclude []string
+
+	matcher *globMatcher // compiled from Include/Exclude by NewRootMappingFs
+}
+
+// RootMappingFs is a filesystem that merges one or more RootMappings into a
+// single virtual tree, e.g. mounting "themes/a/content" and
+// "themes/b/content" both at "content/".
+type RootMappingFs struct {
+	afero.Fs
+
+	// Keyed by RootMapping.From.
+	rootMapToReal map[string][]RootMapping
+}
+
+// NewRootMappingFs creates a new RootMappingFs on top of fs using the given
+// mappings. The mappings' From and To are normalized to use forward slashes
+// and no leading or trailing slash.
+func NewRootMappingFs(fs afero.Fs, rms ...RootMapping) (*RootMappingFs, error) {
+	rootMapToReal := make(map[string][]RootMapping)
+
+	for _, rm := range rms {
+		rm.From = normalizeRootMappingPath(rm.From)
+		rm.To = normalizeRootMappingPath(rm.To)
+
+		if len(rm.Include) > 0 || len(rm.Exclude) > 0 {
+			m, err := newGlobMatcher(rm.Include, rm.Exclude)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid RootMapping for %q", rm.From)
+			}
+			rm.matcher = m
+		}
+
+		rootMapToReal[rm.From] = append(rootMapToReal[rm.From], rm)
+	}
+
+	return &RootMappingFs{Fs: fs, rootMapToReal: rootMapToReal}, nil
+}
+
+func normalizeRootMappingPath(name string) string {
+	return strings.Trim(filepath.ToSlash(name), "/")
+}
+
+// Dirs returns the list of real directories, one per RootMapping, mounted at
+// the virtual path base. It's mainly useful for cases where base is mounted
+// more than once, e.g. once per language or once per theme component, and
+// each of them needs to be consulted (and walked) on its own.
+func (fs *RootMappingFs) Dirs(base string) ([]FileMetaInfo, error) {
+	base = normalizeRootMappingPath(base)
+	rms, found := fs.rootMapToReal[base]
+	if !found {
+		return nil, nil
+	}
+
+	fis := make([]FileMetaInfo, 0, len(rms))
+
+	for _, rm := range rms {
+		fi, err := fs.Fs.Stat(rm.To)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, errors.Wrapf(err, "Dirs: failed to stat %q", rm.To)
+		}
+
+		meta := fs.mergeMeta(fi, rm)
+		meta[metaKeyPath] = rm.From
+
+		fis = append(fis, NewFileMetaInfo(fi.(FileMetaInfo), meta))
+	}
+
+	return fis, nil
+}
+
+// mergeMeta builds the FileMeta for fi as seen through rm: the meta already
+// set on fi by the underlying Fs (Filename, Open, ...) overlaid with rm.Meta
+// (lang, etc.).
+func (fs *RootMappingFs) mergeMeta(fi os.FileInfo, rm RootMapping) FileMeta {
+	meta := FileMeta{}
+	if fim, ok := fi.(FileMetaInfo); ok {
+		for k, v := range fim.Meta() {
+			meta[k] = v
+		}
+	}
+	for k, v := range rm.Meta {
+		meta[k] = v
+	}
+	return meta
+}
+
+// realPath resolves name to the RootMapping that mounts it (matching the
+// longest From) and the corresponding real path below that mapping's To.
+func (fs *RootMappingFs) realPath(name string) (RootMapping, string, bool) {
+	name = normalizeRootMappingPath(name)
+
+	var (
+		best    RootMapping
+		bestLen = -1
+		found   bool
+	)
+
+	for from, rms := range fs.rootMapToReal {
+		if name != from && !strings.HasPrefix(name, from+"/") {
+			continue
+		}
+		if len(from) <= bestLen {
+			continue
+		}
+		bestLen = len(from)
+		// Several mappings may share the same From (e.g. one per language);
+		// without further context we resolve to the first. Use Dirs to get
+		// at the others.
+		best = rms[0]
+		found = true
+	}
+
+	if !found {
+		return RootMapping{}, "", false
+	}
+
+	rel := strings.TrimPrefix(name, best.From)
+	rel = strings.TrimPrefix(rel, "/")
+
+	return best, path.Join(best.To, rel), true
+}
+
+func (fs *RootMappingFs) relToMapping(rm RootMapping, realPath string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(realPath, rm.To), "/")
+}
+
+// relToMappingVirtual is relToMapping's counterpart for a virtual path
+// (relative to rm.From) rather than a real path (relative to rm.To).
+// rootMappingFile.Readdir needs this: it only knows the virtual directory
+// it was opened at, not that directory's real path, once it's anything
+// other than the mapping root.
+func (fs *RootMappingFs) relToMappingVirtual(rm RootMapping, virtualPath string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(virtualPath, rm.From), "/")
+}
+
+func (fs *RootMappingFs) Stat(name string) (os.FileInfo, error) {
+	rm, realName, ok := fs.realPath(name)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	fi, err := fs.Fs.Stat(realName)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.IsDir() && rm.matcher != nil && !rm.matcher.Match(fs.relToMapping(rm, realName)) {
+		return nil, os.ErrNotExist
+	}
+
+	meta := fs.mergeMeta(fi, rm)
+	meta[metaKeyPath] = name
+
+	return NewFileMetaInfo(fi.(FileMetaInfo), meta), nil
+}
+
+func (fs *RootMappingFs) Open(name string) (afero.File, error) {
+	rm, realName, ok := fs.realPath(name)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	if fi, err := fs.Fs.Stat(realName); err == nil && !fi.IsDir() && rm.matcher != nil && !rm.matcher.Match(fs.relToMapping(rm, realName)) {
+		return nil, os.ErrNotExist
+	}
+
+	f, err := fs.Fs.Open(realName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rootMappingFile{File: f, fs: fs, rm: rm, virtualDir: name}, nil
+}
+
+// rootMappingFile decorates a directory's afero.File so that Readdir filters
+// out entries excluded by the owning RootMapping's matcher, and rewrites
+// their virtual path to be relative to the mount point rather than To.
+type rootMappingFile struct {
+	afero.File
+	fs         *RootMappingFs
+	rm         RootMapping
+	virtualDir string
+}
+
+func (f *rootMappingFile) Readdir(count int) ([]os.FileInfo, error) {
+	fis, err := f.File.Readdir(count)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := fis[:0]
+
+	for _, fi := range fis {
+		// f.virtualDir is the virtual path this directory was opened at,
+		// which -- unlike f.rm.To -- is correct for any depth, not just the
+		// mapping root; path.Join(f.rm.To, fi.Name()) would silently drop
+		// every path segment between the mapping root and f once f is a
+		// subdirectory reached by a real walk (open, Readdir, recurse).
+		virtualPath := path.Join(f.virtualDir, fi.Name())
+
+		if !fi.IsDir() && f.rm.matcher != nil {
+			rel := f.fs.relToMappingVirtual(f.rm, virtualPath)
+			if !f.rm.matcher.Match(rel) {
+				continue
+			}
+		}
+
+		if fim, ok := fi.(FileMetaInfo); ok {
+			meta := f.fs.mergeMeta(fi, f.rm)
+			meta[metaKeyPath] = virtualPath
+			fi = NewFileMetaInfo(fim, meta)
+		}
+
+		filtered = append(filtered, fi)
+	}
+
+	return filtered, nil
+}
+
+// globMatcher evaluates a set of doublestar Include/Exclude glob patterns
+// against slash-separated, root-relative paths.
+type globMatcher struct {
+	include []string
+	exclude []string
+}
+
+func newGlobMatcher(include, exclude []string) (*globMatcher, error) {
+	for _, p := range include {
+		if _, err := doublestar.Match(p, "x"); err != nil {
+			return nil, errors.Wrapf(err, "invalid include pattern %q", p)
+		}
+	}
+	for _, p := range exclude {
+		if _, err := doublestar.Match(p, "x"); err != nil {
+			return nil, errors.Wrapf(err, "invalid exclude pattern %q", p)
+		}
+	}
+	return &globMatcher{include: include, exclude: exclude}, nil
+}
+
+// Match reports whether name (slash-separated, relative to the mapping's To)
+// passes the matcher's Include/Exclude patterns.
+func (m *globMatcher) Match(name string) bool {
+	name = filepath.ToSlash(name)
+
+	if len(m.include) > 0 {
+		var included bool
+		for _, p := range m.include {
+			if ok, _ := doublestar.Match(p, name); ok {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+
+	for _, p := range m.exclude {
+		if ok, _ := doublestar.Match(p, name); ok {
+			return false
+		}
+	}
+
+	return true
+}
@@ -15,6 +15,8 @@ package hugofs
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -131,3 +133,105 @@ func TestLanguageMeta(t *testing.T) {
 	assert.Equal(3, len(dirs))
 
 }
+
+func TestRootMappingFsExcludeInclude(t *testing.T) {
+	assert := require.New(t)
+
+	fs := afero.NewMemMapFs()
+
+	assert.NoError(afero.WriteFile(fs, filepath.Join("themes/a/content", "post1.md"), []byte("post1"), 0755))
+	assert.NoError(afero.WriteFile(fs, filepath.Join("themes/a/content", "post2.draft.md"), []byte("post2 draft"), 0755))
+	assert.NoError(afero.WriteFile(fs, filepath.Join("themes/a/content/posts", "post3.md"), []byte("post3"), 0755))
+	assert.NoError(afero.WriteFile(fs, filepath.Join("themes/a/content/notes", "note1.md"), []byte("note1"), 0755))
+
+	bfs := DecorateBasePathFs(afero.NewBasePathFs(fs, "themes").(*afero.BasePathFs))
+
+	rfs, err := NewRootMappingFs(bfs,
+		RootMapping{
+			From:    "content",
+			To:      "a/content",
+			Exclude: []string{"**/*.draft.md"},
+		},
+	)
+	assert.NoError(err)
+
+	_, err = rfs.Stat("content/post1.md")
+	assert.NoError(err)
+
+	_, err = rfs.Stat("content/post2.draft.md")
+	assert.True(os.IsNotExist(err))
+
+	f, err := rfs.Open("content")
+	assert.NoError(err)
+	defer f.Close()
+	entries, err := f.Readdir(-1)
+	assert.NoError(err)
+
+	var names []string
+	for _, fi := range entries {
+		names = append(names, fi.Name())
+	}
+	assert.Contains(names, "post1.md")
+	assert.Contains(names, "posts")
+	assert.NotContains(names, "post2.draft.md")
+
+	rfsInclude, err := NewRootMappingFs(bfs,
+		RootMapping{
+			From:    "content",
+			To:      "a/content",
+			Include: []string{"posts/**"},
+		},
+	)
+	assert.NoError(err)
+
+	_, err = rfsInclude.Stat("content/posts/post3.md")
+	assert.NoError(err)
+
+	_, err = rfsInclude.Stat("content/post1.md")
+	assert.True(os.IsNotExist(err))
+
+	// Readdir on a subdirectory reached by a real walk (open "content",
+	// recurse into "posts", open+Readdir it again) must match the same
+	// result as Stat: the entry's path relative to the mapping has to
+	// account for "posts", not just its own name.
+	fPosts, err := rfsInclude.Open("content/posts")
+	assert.NoError(err)
+	defer fPosts.Close()
+	postsEntries, err := fPosts.Readdir(-1)
+	assert.NoError(err)
+
+	var postsNames []string
+	for _, fi := range postsEntries {
+		postsNames = append(postsNames, fi.Name())
+	}
+	assert.Contains(postsNames, "post3.md")
+}
+
+func TestRootMappingFsExcludeRealFs(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "hugo-rootmapping")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(os.MkdirAll(filepath.Join(dir, "content"), 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "content", "post1.md"), []byte("post1"), 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "content", "post2.draft.md"), []byte("post2 draft"), 0755))
+
+	fs := NewBaseFileDecorator(afero.NewBasePathFs(afero.NewOsFs(), dir))
+
+	rfs, err := NewRootMappingFs(fs,
+		RootMapping{
+			From:    "content",
+			To:      "content",
+			Exclude: []string{"*.draft.md"},
+		},
+	)
+	assert.NoError(err)
+
+	_, err = rfs.Stat("content/post1.md")
+	assert.NoError(err)
+
+	_, err = rfs.Stat("content/post2.draft.md")
+	assert.True(os.IsNotExist(err))
+}
@@ -18,43 +18,115 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 
+	"github.com/gohugoio/hugo/common/loggers"
 	"github.com/spf13/afero"
 )
 
 type WalkFunc func(info FileMetaInfo, err error) error
 
+// WalkHook can be used to filter/amend the files and directories found
+// during the walk, before WalkFn is invoked for each of them.
+type WalkHook func(dir FileMetaInfo, path string, readdir []FileMetaInfo) error
+
+// SymlinkPolicy determines how Walkway handles symlinked directories.
+type SymlinkPolicy int
+
+const (
+	// SymlinkFollowWithCycleDetection follows symlinked directories, but
+	// keeps track of the directories already visited (by device+inode on
+	// Unix, by canonical path elsewhere) so a symlink cycle is detected and
+	// logged instead of walked forever. This is the default (the zero
+	// value), since accidental symlink loops are common, e.g. in theme
+	// components during development.
+	SymlinkFollowWithCycleDetection SymlinkPolicy = iota
+
+	// SymlinkFollow follows symlinked directories unconditionally, without
+	// any cycle detection. A cyclic symlink will make the walk recurse
+	// forever.
+	SymlinkFollow
+
+	// SymlinkDeny refuses to descend into any symlinked directory; Walk
+	// returns an error the first time one is encountered.
+	SymlinkDeny
+)
+
+// WalkwayConfig configures a Walkway.
+type WalkwayConfig struct {
+	Fs         afero.Fs
+	Root       string
+	Info       FileMetaInfo   // Optional pre-fetched FileMetaInfo for Root.
+	DirEntries []FileMetaInfo // Optional pre-fetched dir entries for Root.
+
+	// Logger receives the cycle-detection warnings. May be nil, in which
+	// case they're discarded.
+	Logger *loggers.Logger
+
+	// SymlinkPolicy controls how symlinked directories are handled. The
+	// zero value is SymlinkFollowWithCycleDetection.
+	SymlinkPolicy SymlinkPolicy
+
+	// HookPre, if set, is called for every directory before its entries are
+	// passed to WalkFn, and may return filepath.SkipDir to stop the walker
+	// from descending further into that directory (none of its entries are
+	// then passed to WalkFn).
+	HookPre WalkHook
+
+	WalkFn WalkFunc
+}
+
+// NewWalkwayFromFi is a convenience around NewWalkway for walking a subtree
+// rooted at a FileMetaInfo we already have, using its own Fs.
+func NewWalkwayFromFi(fi FileMetaInfo, walkFn WalkFunc) *Walkway {
+	return NewWalkway(WalkwayConfig{Fs: fi.Meta().Fs(), Info: fi, WalkFn: walkFn})
+}
+
+func NewWalkway(cfg WalkwayConfig) *Walkway {
+	return &Walkway{
+		fs:            cfg.Fs,
+		root:          cfg.Root,
+		fi:            cfg.Info,
+		dirEntries:    cfg.DirEntries,
+		logger:        cfg.Logger,
+		symlinkPolicy: cfg.SymlinkPolicy,
+		hookPre:       cfg.HookPre,
+		walkFn:        cfg.WalkFn,
+		seenDirs:      make(map[string]string),
+	}
+}
+
 type Walkway struct {
 	fs   afero.Fs
 	root string
 	fi   FileMetaInfo
 
-	walkFn WalkFunc
-	walked bool
+	// Preset by NewWalkway if the root's FileMetaInfo/dir entries are
+	// already known, e.g. when continuing a walk from a given directory.
+	dirEntries []FileMetaInfo
 
-	// We may traverse symbolic links and bite ourself.
-	seen map[string]bool
-}
+	logger *loggers.Logger
 
-func NewWalkway(fs afero.Fs, root string, walkFn WalkFunc) *Walkway {
-	return &Walkway{
-		fs:     fs,
-		root:   root,
-		walkFn: walkFn,
-		seen:   make(map[string]bool)}
-}
+	symlinkPolicy SymlinkPolicy
 
-func NewWalkwayFromFi(fi FileMetaInfo, walkFn WalkFunc) *Walkway {
-	return &Walkway{
-		fs:     fi.Meta().Fs(),
-		fi:     fi,
-		walkFn: walkFn,
-		seen:   make(map[string]bool)}
+	hookPre WalkHook
+	walkFn  WalkFunc
+	walked  bool
+
+	// Protects seenDirs; Walkway itself isn't meant to be shared across
+	// goroutines, but keep this safe regardless of how it's constructed.
+	mu sync.Mutex
+
+	// Maps a visited directory's real identity (device+inode on Unix,
+	// canonical path elsewhere, see fileKey) to the path it was first
+	// reached via. Used to detect and report symlink cycles.
+	seenDirs map[string]string
 }
 
-// TODO(bep) make content use this
+// Walk walks the file tree rooted at the Walkway's root, calling walkFn for
+// each file or directory in the tree, including root.
 func (w *Walkway) Walk() error {
 	if w.walked {
 		panic("this walkway is already walked")
@@ -80,8 +152,7 @@ func (w *Walkway) Walk() error {
 		return w.walkFn(nil, errors.New("file to walk must be a directory"))
 	}
 
-	return w.walk(w.root, fi, w.walkFn)
-
+	return w.walk(w.root, fi, w.dirEntries, w.walkFn)
 }
 
 // if the filesystem supports it, use Lstat, else use fs.Stat
@@ -93,9 +164,10 @@ func lstatIfPossible(fs afero.Fs, path string) (os.FileInfo, error) {
 	return fs.Stat(path)
 }
 
-// walk recursively descends path, calling walkFn.
-// It follow symlinks if supported by the filesystem, but only the same path once.
-func (w *Walkway) walk(path string, info FileMetaInfo, walkFn WalkFunc) error {
+// walk recursively descends path, calling walkFn. It follows symlinks if
+// supported by the filesystem and allowed by the SymlinkPolicy, guarding
+// against cycles along the way.
+func (w *Walkway) walk(path string, info FileMetaInfo, dirEntries []FileMetaInfo, walkFn WalkFunc) error {
 	err := walkFn(info, nil)
 	if err != nil {
 		if info.IsDir() && err == filepath.SkipDir {
@@ -107,47 +179,57 @@ func (w *Walkway) walk(path string, info FileMetaInfo, walkFn WalkFunc) error {
 		return nil
 	}
 
-	meta := info.Meta()
-	filename := meta.Filename()
-	filenameToOpen := path // may be a composite
-	openFs := w.fs
-
-	if meta.IsSymlink() {
-		// Symlinks will only work in the filesystems defined by the project,
-		// (not theme components), and we do follow them.
-		filenameToOpen = filename
-		// This is a full filename to a file on the Os filesystem.
-		openFs = osDecorated
+	// Register this directory's identity before descending into it -- not
+	// just symlink targets, see checkSymlinkCycle -- so a symlink anywhere
+	// below that resolves back to it (the walk root is the most common real-
+	// world case) is recognized as a cycle immediately, rather than one
+	// level too late.
+	w.markSeen(info, path)
 
-	}
+	meta := info.Meta()
 
-	// Prevent infinite recursion.
-	w.isSeen(filename)
+	fis := dirEntries
+	if fis == nil {
+		filename := meta.Filename()
+		filenameToOpen := path // may be a composite
+		openFs := w.fs
+
+		if meta.IsSymlink() {
+			// Symlinks will only work in the filesystems defined by the
+			// project (not theme components), and we do follow them.
+			filenameToOpen = filename
+			// This is a full filename to a file on the Os filesystem.
+			openFs = osDecorated
+		}
 
-	f, err := openFs.Open(filenameToOpen)
+		f, err := openFs.Open(filenameToOpen)
+		if err != nil {
+			return walkFn(info, errors.Wrapf(err, "walk: open %q (path: %q)", filenameToOpen, path))
+		}
 
-	if err != nil {
-		return walkFn(info, errors.Wrapf(err, "walk: open %q (path: %q)", filenameToOpen, path))
-	}
+		fis, err = readdirAsFileMetaInfos(f)
+		f.Close()
+		if err != nil {
+			return walkFn(info, err)
+		}
 
-	fis, err := f.Readdir(-1)
-	f.Close()
-	if err != nil {
-		return walkFn(info, err)
+		if !meta.IsOrdered() {
+			sort.Slice(fis, func(i, j int) bool {
+				return fis[i].Name() < fis[j].Name()
+			})
+		}
 	}
 
-	if !meta.IsOrdered() {
-		sort.Slice(fis, func(i, j int) bool {
-			fii := fis[i].(FileMetaInfo)
-			fij := fis[j].(FileMetaInfo)
-			return fii.Name() < fij.Name()
-		})
+	if w.hookPre != nil {
+		if err := w.hookPre(info, path, fis); err != nil {
+			if err == filepath.SkipDir {
+				return nil
+			}
+			return err
+		}
 	}
 
-	for _, fi := range fis {
-		fim := fi.(FileMetaInfo)
-		var err error
-
+	for _, fim := range fis {
 		meta := fim.Meta()
 
 		// Note that we use the original Name even if it's a symlink.
@@ -155,25 +237,18 @@ func (w *Walkway) walk(path string, info FileMetaInfo, walkFn WalkFunc) error {
 
 		meta[metaKeyPath] = w.relativePath(pathn)
 
-		if err != nil {
-			return walkFn(fim, err)
-		}
-
 		if fim.IsDir() {
-
-			// Prevent infinite recursion
-			filename := meta.Filename()
-			if w.isSeen(filename) && meta.IsSymlink() {
-				// Possible cyclic reference
-				// TODO(bep) mod check if we log some warning about this in the
-				// existing content walker.
+			skip, err := w.checkSymlinkCycle(fim, pathn)
+			if err != nil {
+				return err
+			}
+			if skip {
 				continue
 			}
 		}
 
-		err = w.walk(pathn, fim, walkFn)
-		if err != nil {
-			if !fi.IsDir() || err != filepath.SkipDir {
+		if err := w.walk(pathn, fim, nil, walkFn); err != nil {
+			if !fim.IsDir() || err != filepath.SkipDir {
 				return err
 			}
 		}
@@ -181,13 +256,78 @@ func (w *Walkway) walk(path string, info FileMetaInfo, walkFn WalkFunc) error {
 	return nil
 }
 
-func (w *Walkway) isSeen(filename string) bool {
-	if w.seen[filename] {
-		return true
+// checkSymlinkCycle applies the configured SymlinkPolicy to a directory
+// about to be walked into. It returns skip=true if the walker should not
+// descend into dir (a cycle was detected and logged), or a non-nil error if
+// SymlinkDeny forbids the descent outright.
+func (w *Walkway) checkSymlinkCycle(dir FileMetaInfo, pathn string) (bool, error) {
+	meta := dir.Meta()
+	if !meta.IsSymlink() {
+		return false, nil
+	}
+
+	if w.symlinkPolicy == SymlinkFollow {
+		return false, nil
+	}
+
+	filename := meta.Filename()
+
+	target, err := filepath.EvalSymlinks(filename)
+	if err != nil {
+		return false, errors.Wrapf(err, "walk: failed to resolve symlink %q", filename)
+	}
+
+	if w.symlinkPolicy == SymlinkDeny {
+		return false, errors.Errorf("walk: %q is a symlinked directory (pointing to %q), which is denied by the current SymlinkPolicy", pathn, target)
+	}
+
+	targetInfo, err := os.Stat(target)
+	if err != nil {
+		return false, errors.Wrapf(err, "walk: failed to stat symlink target %q", target)
+	}
+
+	firstSeenVia, seen := w.markSeen(targetInfo, target)
+
+	if seen {
+		if w.logger != nil {
+			w.logger.WARN.Printf("walk: symlink cycle detected: %q re-enters %q (first reached via %q); skipping", pathn, target, firstSeenVia)
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// markSeen records fi's identity (see fileKey) as reached via path, unless
+// it's already recorded (under any path), in which case this is a
+// repeat visit -- a symlink cycle, or two different paths (symlinked or not)
+// converging on the same real directory -- and the path it was first seen
+// via is returned.
+func (w *Walkway) markSeen(fi os.FileInfo, path string) (firstSeenVia string, alreadySeen bool) {
+	key := fileKey(fi, path)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if via, seen := w.seenDirs[key]; seen {
+		return via, true
+	}
+	w.seenDirs[key] = path
+	return "", false
+}
+
+func readdirAsFileMetaInfos(f afero.File) ([]FileMetaInfo, error) {
+	fis, err := f.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	fims := make([]FileMetaInfo, len(fis))
+	for i, fi := range fis {
+		fims[i] = fi.(FileMetaInfo)
 	}
 
-	w.seen[filename] = true
-	return false
+	return fims, nil
 }
 
 func (w *Walkway) relativePath(path string) string {
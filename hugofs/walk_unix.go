@@ -0,0 +1,32 @@
+// Copyright 2019 The Hugo Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !windows
+
+package hugofs
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileKey returns a string uniquely identifying the file on disk, used to
+// detect symlink cycles. On Unix this is the device+inode pair, which
+// survives the file being reached via different paths.
+func fileKey(fi os.FileInfo, path string) string {
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return fmt.Sprintf("%d:%d", stat.Dev, stat.Ino)
+	}
+	return path
+}
@@ -0,0 +1,37 @@
+// Copyright 2019 The Hugo Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build windows
+
+package hugofs
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// fileKey returns a string uniquely identifying the file on disk, used to
+// detect symlink cycles. Windows has no cheap, portable device+inode
+// equivalent exposed via os.FileInfo, so fall back to the canonical
+// (symlink-resolved) path.
+func fileKey(fi os.FileInfo, path string) string {
+	if fim, ok := fi.(FileMetaInfo); ok {
+		if filename := fim.Meta().Filename(); filename != "" {
+			path = filename
+		}
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return path
+}
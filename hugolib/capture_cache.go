@@ -0,0 +1,337 @@
+// Copyright 2019 The Hugo Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hugolib
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/pkg/errors"
+
+	"github.com/gohugoio/hugo/common/hugio"
+	"github.com/gohugoio/hugo/hugofs"
+)
+
+// captureCacheSchema is bumped whenever the on-disk format of captureCache
+// changes; an index written by an older/newer schema is ignored rather than
+// partially decoded.
+const captureCacheSchema = 1
+
+// captureCacheEntry is the persisted record for a single real file: its
+// content hash (only recomputed when size/mtime disagree with what produced
+// it, see captureCache.fileHash) plus the size/mtime pair used for that
+// fast-path check.
+type captureCacheEntry struct {
+	Hash    uint64
+	Size    int64
+	ModTime time.Time
+}
+
+type captureCacheIndex struct {
+	Schema  int
+	Entries map[string]captureCacheEntry
+}
+
+// nonBundleOp is one handler dispatch (handleSingles/handleCopyFile)
+// recorded while classifying a plain (non-bundle) directory, so it can be
+// replayed verbatim the next time that directory's content is unchanged.
+type nonBundleOp struct {
+	single   *fileInfo
+	copyMeta hugofs.FileMeta
+}
+
+type dirOpRecorder struct {
+	ops []nonBundleOp
+}
+
+func (r *dirOpRecorder) recordSingle(f *fileInfo) { r.ops = append(r.ops, nonBundleOp{single: f}) }
+func (r *dirOpRecorder) recordCopyFile(m hugofs.FileMeta) {
+	r.ops = append(r.ops, nonBundleOp{copyMeta: m})
+}
+
+// bundleCacheEntry is what's replayed on a handleBundleDirCached cache hit:
+// any singles dispatched directly from that level (e.g. the regular content
+// pages next to a branch bundle's _index file), plus the bundleDirs built for it, if the directory
+// turned out to own a bundle at all.
+type bundleCacheEntry struct {
+	singles []*fileInfo
+	bundle  *bundleDirs
+}
+
+// captureCache persists per-file content hashes between capturer runs (under
+// dir, versioned by captureCacheSchema) and, for the lifetime of the running
+// process, the classified *fileInfo/handler ops produced for a directory
+// whose content hash has not changed. The latter is what lets capture() and
+// capturePartial() skip the actual classification work for unchanged
+// subtrees; the former is what lets that decision be made without re-reading
+// every file's bytes on every rebuild.
+type captureCache struct {
+	dir string
+
+	mu    sync.Mutex
+	index captureCacheIndex
+
+	// realPath -> aggregate hash of the directory's direct entries the last
+	// time handleNonBundleCached ran for it in this process.
+	dirHashes map[string]uint64
+
+	// realPath -> the ops to replay when dirHashes[realPath] still matches.
+	// Not persisted: only useful within a single long-running process (e.g.
+	// hugo server), since *fileInfo isn't meaningfully serializable.
+	dirOps map[string][]nonBundleOp
+
+	// realPath -> aggregate hash of the directory's bundle-relevant content
+	// the last time handleBundleDirCached ran for it in this process. For a
+	// leaf bundle this covers its whole subtree (see capturer.hashDir); for
+	// everything else it's the same shallow hash as dirHashes.
+	bundleHashes map[string]uint64
+
+	// realPath -> the entry to replay when bundleHashes[realPath] still
+	// matches. Not persisted, for the same reason as dirOps.
+	bundleEntries map[string]*bundleCacheEntry
+
+	dirty bool
+}
+
+func newCaptureCache(dir string) *captureCache {
+	c := &captureCache{
+		dir:           dir,
+		dirHashes:     make(map[string]uint64),
+		dirOps:        make(map[string][]nonBundleOp),
+		bundleHashes:  make(map[string]uint64),
+		bundleEntries: make(map[string]*bundleCacheEntry),
+	}
+	c.load()
+	return c
+}
+
+func (c *captureCache) indexFilename() string {
+	return filepath.Join(c.dir, fmt.Sprintf("capture_%d.json", captureCacheSchema))
+}
+
+func (c *captureCache) load() {
+	b, err := ioutil.ReadFile(c.indexFilename())
+	if err != nil {
+		c.index = captureCacheIndex{Schema: captureCacheSchema, Entries: make(map[string]captureCacheEntry)}
+		return
+	}
+
+	var idx captureCacheIndex
+	if err := json.Unmarshal(b, &idx); err != nil || idx.Schema != captureCacheSchema || idx.Entries == nil {
+		c.index = captureCacheIndex{Schema: captureCacheSchema, Entries: make(map[string]captureCacheEntry)}
+		return
+	}
+
+	c.index = idx
+}
+
+// persist writes the file-hash index to disk. It's cheap to call often: a
+// no-op unless fileHash has added or updated an entry, or invalidate has
+// dropped one, since the last call.
+func (c *captureCache) persist() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	if err := os.MkdirAll(c.dir, 0777); err != nil {
+		return errors.Wrap(err, "captureCache: failed to create cache dir")
+	}
+
+	b, err := json.Marshal(c.index)
+	if err != nil {
+		return errors.Wrap(err, "captureCache: failed to marshal index")
+	}
+
+	if err := ioutil.WriteFile(c.indexFilename(), b, 0666); err != nil {
+		return errors.Wrap(err, "captureCache: failed to write index")
+	}
+
+	c.dirty = false
+
+	return nil
+}
+
+// fileHash returns the content hash for realPath. If fi's size and mtime
+// match the last recorded entry, the cached hash is returned without
+// opening the file; otherwise open is used to read it and compute a new
+// xxhash, which is then cached.
+func (c *captureCache) fileHash(realPath string, fi os.FileInfo, open func() (hugio.ReadSeekCloser, error)) (captureCacheEntry, error) {
+	c.mu.Lock()
+	prev, found := c.index.Entries[realPath]
+	c.mu.Unlock()
+
+	if found && prev.Size == fi.Size() && prev.ModTime.Equal(fi.ModTime()) {
+		return prev, nil
+	}
+
+	f, err := open()
+	if err != nil {
+		return captureCacheEntry{}, errors.Wrapf(err, "captureCache: failed to open %q", realPath)
+	}
+	defer f.Close()
+
+	h := xxhash.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return captureCacheEntry{}, errors.Wrapf(err, "captureCache: failed to hash %q", realPath)
+	}
+
+	entry := captureCacheEntry{Hash: h.Sum64(), Size: fi.Size(), ModTime: fi.ModTime()}
+
+	c.mu.Lock()
+	c.index.Entries[realPath] = entry
+	c.dirty = true
+	c.mu.Unlock()
+
+	return entry, nil
+}
+
+// invalidate drops any cached content hash and dir-level state for
+// realPath, forcing the next capture to re-read and reclassify it. Used by
+// capturePartial for paths reported as changed.
+func (c *captureCache) invalidate(realPath string) {
+	c.mu.Lock()
+	delete(c.index.Entries, realPath)
+	delete(c.dirHashes, realPath)
+	delete(c.dirOps, realPath)
+	delete(c.bundleHashes, realPath)
+	delete(c.bundleEntries, realPath)
+	c.dirty = true
+	c.mu.Unlock()
+}
+
+// aggregate combines hashes into a single order-independent hash, so the
+// result doesn't depend on the directory listing order.
+func aggregate(hashes []uint64) uint64 {
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+
+	agg := xxhash.New()
+	var buf [8]byte
+	for _, h := range hashes {
+		binary.LittleEndian.PutUint64(buf[:], h)
+		agg.Write(buf[:])
+	}
+
+	return agg.Sum64()
+}
+
+// hashEntries computes an aggregate, order-independent hash for a
+// directory's direct entries: for files, their content hash (via hashFile);
+// for subdirectories, their name and mtime only (their own content is
+// covered by their own hashEntries call once walked).
+func (c *captureCache) hashEntries(files []os.FileInfo, hashFile func(os.FileInfo) (uint64, error)) (uint64, error) {
+	hashes := make([]uint64, 0, len(files))
+
+	for _, fi := range files {
+		var h uint64
+		if fi.IsDir() {
+			h = xxhash.Sum64String(fi.Name() + "\x00" + fi.ModTime().String())
+		} else {
+			fh, err := hashFile(fi)
+			if err != nil {
+				return 0, err
+			}
+			h = fh ^ xxhash.Sum64String(fi.Name())
+		}
+		hashes = append(hashes, h)
+	}
+
+	return aggregate(hashes), nil
+}
+
+// hashEntriesDeep is like hashEntries, but a subdirectory's hash is computed
+// recursively via hashDir instead of from its name and mtime alone. Used for
+// leaf bundles, which fold a whole subtree (resource folders included) into
+// one bundle, so a change anywhere below has to be observed here rather
+// than at that subdirectory's own, separately cached level.
+func (c *captureCache) hashEntriesDeep(files []os.FileInfo, hashFile, hashDir func(os.FileInfo) (uint64, error)) (uint64, error) {
+	hashes := make([]uint64, 0, len(files))
+
+	for _, fi := range files {
+		var (
+			h   uint64
+			err error
+		)
+		if fi.IsDir() {
+			h, err = hashDir(fi)
+		} else {
+			h, err = hashFile(fi)
+		}
+		if err != nil {
+			return 0, err
+		}
+		hashes = append(hashes, h^xxhash.Sum64String(fi.Name()))
+	}
+
+	return aggregate(hashes), nil
+}
+
+// lookup reports whether realPath's directory ops from a previous run in
+// this process are still valid for the given aggregate hash.
+func (c *captureCache) lookup(realPath string, hash uint64) ([]nonBundleOp, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prevHash, ok := c.dirHashes[realPath]
+	if !ok || prevHash != hash {
+		return nil, false
+	}
+
+	ops, ok := c.dirOps[realPath]
+	return ops, ok
+}
+
+// store records realPath's aggregate hash and the ops produced for it, so a
+// later call with the same hash can replay them via lookup.
+func (c *captureCache) store(realPath string, hash uint64, ops []nonBundleOp) {
+	c.mu.Lock()
+	c.dirHashes[realPath] = hash
+	c.dirOps[realPath] = ops
+	c.mu.Unlock()
+}
+
+// lookupBundle is the bundleHashes/bundleEntries counterpart of lookup, used
+// by handleBundleDirCached.
+func (c *captureCache) lookupBundle(realPath string, hash uint64) (*bundleCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prevHash, ok := c.bundleHashes[realPath]
+	if !ok || prevHash != hash {
+		return nil, false
+	}
+
+	entry, ok := c.bundleEntries[realPath]
+	return entry, ok
+}
+
+// storeBundle is the bundleHashes/bundleEntries counterpart of store, used
+// by handleBundleDirCached.
+func (c *captureCache) storeBundle(realPath string, hash uint64, entry *bundleCacheEntry) {
+	c.mu.Lock()
+	c.bundleHashes[realPath] = hash
+	c.bundleEntries[realPath] = entry
+	c.mu.Unlock()
+}
@@ -0,0 +1,257 @@
+// Copyright 2019 The Hugo Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hugolib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gohugoio/hugo/common/hugio"
+	"github.com/stretchr/testify/require"
+)
+
+func openerFor(path string) func() (hugio.ReadSeekCloser, error) {
+	return func() (hugio.ReadSeekCloser, error) {
+		return os.Open(path)
+	}
+}
+
+func TestCaptureCacheFileHash(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "hugo-capture-cache")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "f.txt")
+	assert.NoError(ioutil.WriteFile(path, []byte("content v1"), 0666))
+
+	c := newCaptureCache(filepath.Join(dir, "cache"))
+
+	fi, err := os.Stat(path)
+	assert.NoError(err)
+
+	e1, err := c.fileHash(path, fi, openerFor(path))
+	assert.NoError(err)
+	assert.NotZero(e1.Hash)
+
+	// Same size/mtime: fileHash must return the same (cached) entry without
+	// needing to read the file again.
+	e2, err := c.fileHash(path, fi, openerFor(path))
+	assert.NoError(err)
+	assert.Equal(e1.Hash, e2.Hash)
+
+	// Changed content with a bumped mtime must produce a different hash.
+	assert.NoError(ioutil.WriteFile(path, []byte("content v2, and longer"), 0666))
+	newTime := fi.ModTime().Add(time.Second)
+	assert.NoError(os.Chtimes(path, newTime, newTime))
+
+	fi2, err := os.Stat(path)
+	assert.NoError(err)
+
+	e3, err := c.fileHash(path, fi2, openerFor(path))
+	assert.NoError(err)
+	assert.NotEqual(e1.Hash, e3.Hash)
+}
+
+func TestCaptureCacheHashEntriesOrderIndependent(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "hugo-capture-cache")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0666))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "b.txt"), []byte("b"), 0666))
+
+	fia, err := os.Stat(filepath.Join(dir, "a.txt"))
+	assert.NoError(err)
+	fib, err := os.Stat(filepath.Join(dir, "b.txt"))
+	assert.NoError(err)
+
+	c := newCaptureCache(filepath.Join(dir, "cache"))
+
+	hashFile := func(fi os.FileInfo) (uint64, error) {
+		return c.fileHashFor(dir, fi)
+	}
+
+	h1, err := c.hashEntries([]os.FileInfo{fia, fib}, hashFile)
+	assert.NoError(err)
+	h2, err := c.hashEntries([]os.FileInfo{fib, fia}, hashFile)
+	assert.NoError(err)
+
+	assert.Equal(h1, h2)
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "b.txt"), []byte("changed"), 0666))
+	bTime := fib.ModTime().Add(time.Second)
+	assert.NoError(os.Chtimes(filepath.Join(dir, "b.txt"), bTime, bTime))
+	fib2, err := os.Stat(filepath.Join(dir, "b.txt"))
+	assert.NoError(err)
+
+	h3, err := c.hashEntries([]os.FileInfo{fia, fib2}, hashFile)
+	assert.NoError(err)
+	assert.NotEqual(h1, h3)
+}
+
+// fileHashFor is a small test helper bridging hashEntries' hashFile callback
+// (which only gets an os.FileInfo) to fileHash (which also wants the real
+// path and an opener).
+func (c *captureCache) fileHashFor(dir string, fi os.FileInfo) (uint64, error) {
+	path := filepath.Join(dir, fi.Name())
+	entry, err := c.fileHash(path, fi, openerFor(path))
+	if err != nil {
+		return 0, err
+	}
+	return entry.Hash, nil
+}
+
+func TestCaptureCacheHashEntriesDeepPicksUpNestedChange(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "hugo-capture-cache")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0666))
+
+	subDir := filepath.Join(dir, "sub")
+	assert.NoError(os.Mkdir(subDir, 0755))
+	nestedPath := filepath.Join(subDir, "nested.txt")
+	assert.NoError(ioutil.WriteFile(nestedPath, []byte("nested v1"), 0666))
+
+	c := newCaptureCache(filepath.Join(dir, "cache"))
+
+	hashFileIn := func(d string) func(os.FileInfo) (uint64, error) {
+		return func(fi os.FileInfo) (uint64, error) {
+			return c.fileHashFor(d, fi)
+		}
+	}
+
+	readDirAsFileInfos := func(d string) []os.FileInfo {
+		entries, err := ioutil.ReadDir(d)
+		assert.NoError(err)
+		fis := make([]os.FileInfo, len(entries))
+		for i, e := range entries {
+			fis[i] = e
+		}
+		return fis
+	}
+
+	var hashDir func(fi os.FileInfo) (uint64, error)
+	hashDir = func(fi os.FileInfo) (uint64, error) {
+		sub := filepath.Join(dir, fi.Name())
+		return c.hashEntriesDeep(readDirAsFileInfos(sub), hashFileIn(sub), hashDir)
+	}
+
+	h1, err := c.hashEntriesDeep(readDirAsFileInfos(dir), hashFileIn(dir), hashDir)
+	assert.NoError(err)
+
+	// A change buried in the nested subdirectory must change the aggregate
+	// hash -- this is the whole point of hashEntriesDeep over the shallow
+	// hashEntries, which would only look at sub's own name+mtime and miss it.
+	nestedFi, err := os.Stat(nestedPath)
+	assert.NoError(err)
+	assert.NoError(ioutil.WriteFile(nestedPath, []byte("nested v2, changed"), 0666))
+	newTime := nestedFi.ModTime().Add(time.Second)
+	assert.NoError(os.Chtimes(nestedPath, newTime, newTime))
+
+	h2, err := c.hashEntriesDeep(readDirAsFileInfos(dir), hashFileIn(dir), hashDir)
+	assert.NoError(err)
+
+	assert.NotEqual(h1, h2)
+}
+
+func TestCaptureCacheStoreLookupBundleInvalidate(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "hugo-capture-cache")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	c := newCaptureCache(filepath.Join(dir, "cache"))
+
+	entry := &bundleCacheEntry{singles: []*fileInfo{{}}}
+	c.storeBundle("/content/blog/post1", 42, entry)
+
+	got, ok := c.lookupBundle("/content/blog/post1", 42)
+	assert.True(ok)
+	assert.True(got == entry)
+
+	_, ok = c.lookupBundle("/content/blog/post1", 43)
+	assert.False(ok)
+
+	_, ok = c.lookupBundle("/content/blog/post2", 42)
+	assert.False(ok)
+
+	c.invalidate("/content/blog/post1")
+	_, ok = c.lookupBundle("/content/blog/post1", 42)
+	assert.False(ok)
+}
+
+func TestCaptureCacheStoreLookupInvalidate(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "hugo-capture-cache")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	c := newCaptureCache(filepath.Join(dir, "cache"))
+
+	ops := []nonBundleOp{{copyMeta: nil}}
+	c.store("/content/section", 42, ops)
+
+	got, ok := c.lookup("/content/section", 42)
+	assert.True(ok)
+	assert.Equal(ops, got)
+
+	_, ok = c.lookup("/content/section", 43)
+	assert.False(ok)
+
+	_, ok = c.lookup("/content/other", 42)
+	assert.False(ok)
+
+	c.invalidate("/content/section")
+	_, ok = c.lookup("/content/section", 42)
+	assert.False(ok)
+}
+
+func TestCaptureCachePersistLoadRoundTrip(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "hugo-capture-cache")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	cacheDir := filepath.Join(dir, "cache")
+
+	path := filepath.Join(dir, "f.txt")
+	assert.NoError(ioutil.WriteFile(path, []byte("content"), 0666))
+	fi, err := os.Stat(path)
+	assert.NoError(err)
+
+	c1 := newCaptureCache(cacheDir)
+	entry, err := c1.fileHash(path, fi, openerFor(path))
+	assert.NoError(err)
+	assert.NoError(c1.persist())
+
+	c2 := newCaptureCache(cacheDir)
+	assert.Equal(captureCacheSchema, c2.index.Schema)
+
+	got, found := c2.index.Entries[path]
+	assert.True(found)
+	assert.Equal(entry.Hash, got.Hash)
+}
@@ -19,6 +19,7 @@ import (
 	"path"
 	"path/filepath"
 
+	"github.com/cespare/xxhash/v2"
 	"github.com/pkg/errors"
 
 	"github.com/gohugoio/hugo/config"
@@ -63,6 +64,11 @@ type capturer struct {
 
 	// Semaphore used to throttle the concurrent sub directory handling.
 	sem chan bool
+
+	// Caches per-file content hashes and, within this process, the
+	// classification results for plain directories whose content hasn't
+	// changed since the last capture. See handleNonBundleCached.
+	cache *captureCache
 }
 
 func newCapturer(
@@ -70,6 +76,7 @@ func newCapturer(
 	sourceSpec *source.SourceSpec,
 	handler captureResultHandler,
 	contentChanges *contentChangeMap,
+	backends []SourceBackend,
 	filenames ...string) *capturer {
 
 	numWorkers := config.GetNumWorkerMultiplier()
@@ -98,19 +105,45 @@ func newCapturer(
 		return a < b
 	})
 
+	// Layer any extra SourceBackends (git, tar, HTTP, ...) in front of the
+	// project's own SourceFs, so content can be assembled from mixed
+	// origins, e.g. a theme from git and content from a tar archive. The
+	// project's own SourceFs is itself wrapped as a (local) SourceBackend and
+	// appended last, so it's still reachable for anything none of the extra
+	// backends serve.
+	fs := sourceSpec.SourceFs
+	if len(backends) > 0 {
+		layered := make([]SourceBackend, 0, len(backends)+1)
+		layered = append(layered, backends...)
+		layered = append(layered, newLocalSourceBackend(sourceSpec.SourceFs))
+		fs = newLayeredBackendFs(layered)
+	}
+
 	c := &capturer{
 		sem:            make(chan bool, numWorkers),
 		handler:        handler,
 		sourceSpec:     sourceSpec,
-		fs:             sourceSpec.SourceFs,
+		fs:             fs,
 		logger:         logger,
 		contentChanges: contentChanges,
 		seen:           make(map[string]bool),
-		filenames:      filenames}
+		filenames:      filenames,
+		cache:          newCaptureCache(captureCacheDir())}
 
 	return c
 }
 
+// captureCacheDir returns where the capturer's on-disk content-hash index is
+// stored.
+//
+// TODO(bep) mod wire this up to the project's configured cache directory
+// (PathSpec.CacheDir) once that's reachable from here; for now every project
+// on the machine shares one, which is fine since entries are keyed by their
+// absolute real path.
+func captureCacheDir() string {
+	return filepath.Join(os.TempDir(), "hugo_cache", "capture")
+}
+
 // Captured files and bundles ready to be processed will be passed on to
 // these channels.
 type captureResultHandler interface {
@@ -153,6 +186,12 @@ func (c *capturer) capturePartial(filenames ...string) error {
 	handled := make(map[string]bool)
 
 	for _, filename := range filenames {
+		// The changed file's directory (and the file itself, if it turns
+		// out to still exist) must be reclassified, so drop anything the
+		// cache knows about it.
+		c.cache.invalidate(filename)
+		c.cache.invalidate(filepath.Dir(filename))
+
 		dir, resolvedFilename, tp := c.contentChanges.resolveAndRemove(filename)
 		if handled[resolvedFilename] {
 			continue
@@ -215,6 +254,14 @@ func (c *capturer) capturePartial(filenames ...string) error {
 // Pick lang from FileInfo.Lang() or FileInfo.Fs().Lang?
 // Start everything from a dir FileInfo
 func (c *capturer) capture() error {
+	// Persist whatever fileHash added to the on-disk index this run,
+	// regardless of how capture() returns.
+	defer func() {
+		if err := c.cache.persist(); err != nil {
+			c.logger.WARN.Printf("capture: failed to persist capture cache: %s", err)
+		}
+	}()
+
 	if len(c.filenames) > 0 {
 		return c.capturePartial(c.filenames...)
 	}
@@ -384,6 +431,10 @@ func (c *capturer) handleDir(dirname hugofs.FileMetaInfo) error {
 			} else {
 				hasNonContent = true
 			}
+
+			if bundleType == bundleNot && tp != bundleNot {
+				bundleType = tp
+			}
 		}
 	}
 
@@ -393,9 +444,76 @@ func (c *capturer) handleDir(dirname hugofs.FileMetaInfo) error {
 	}
 
 	if state > dirStateDefault {
-		return c.handleNonBundle(dirname, files, state == dirStateSinglesOnly)
+		return c.handleNonBundleCached(dirname, files, state == dirStateSinglesOnly)
 	}
 
+	return c.handleBundleDirCached(dirname, files, fileBundleTypes, bundleType)
+}
+
+// handleBundleDirCached is a cache-aware wrapper around the bundle
+// classification below (the rest of what used to be handleDir). Page
+// bundles -- any directory with an index.md/_index.md -- are the dominant
+// Hugo content layout, so caching here (not just the assets-only/
+// singles-only dirs handleNonBundleCached covers) is what actually matters
+// for cutting warm-rebuild time on large sites.
+//
+// A leaf bundle folds its whole subtree (including resource folders) into
+// one bundle via createBundleDirs/collectFiles, so the cache key has to
+// cover that whole subtree: hashDir hashes recursively. A branch bundle or
+// plain section only ever dispatches its own direct entries from here --
+// any subdirectories are walked independently via handleNestedDir (itself
+// cache-aware at its own level) -- so the cheaper, shallow hashEntries
+// (name+mtime for dirs) is enough to key it.
+func (c *capturer) handleBundleDirCached(dirname hugofs.FileMetaInfo, files []os.FileInfo, fileBundleTypes []bundleDirType, bundleType bundleDirType) error {
+	realPath := dirname.Meta().Filename()
+
+	var (
+		hash    uint64
+		hashErr error
+	)
+
+	if bundleType == bundleLeaf {
+		hash, hashErr = c.cache.hashEntriesDeep(files, c.hashFile, c.hashDir)
+	} else {
+		hash, hashErr = c.cache.hashEntries(files, c.hashFile)
+	}
+
+	if hashErr == nil {
+		if entry, ok := c.cache.lookupBundle(realPath, hash); ok {
+			for _, f := range entry.singles {
+				c.copyOrHandleSingle(f)
+			}
+			if entry.bundle != nil {
+				c.handler.handleBundles(entry.bundle)
+			}
+			if bundleType != bundleLeaf {
+				return c.handleNonBundleDirs(files)
+			}
+			return nil
+		}
+	}
+
+	entry, err := c.handleBundleDir(dirname, files, fileBundleTypes, bundleType)
+	if err != nil {
+		return err
+	}
+
+	if hashErr == nil {
+		c.cache.storeBundle(realPath, hash, entry)
+	}
+
+	return nil
+}
+
+// handleBundleDir classifies dirname's files (already known not to be an
+// assets-only or singles-only dir, see handleDir) and dispatches them --
+// singles via copyOrHandleSingle, nested dirs via handleNestedDir, a bundle
+// (if any) via handleBundles -- recording what was dispatched into the
+// returned bundleCacheEntry so handleBundleDirCached can replay it on a
+// later unchanged run.
+func (c *capturer) handleBundleDir(dirname hugofs.FileMetaInfo, files []os.FileInfo, fileBundleTypes []bundleDirType, bundleType bundleDirType) (*bundleCacheEntry, error) {
+	entry := &bundleCacheEntry{}
+
 	var fileInfos = make([]*fileInfo, 0, len(files))
 
 	for i, fi := range files {
@@ -415,7 +533,7 @@ func (c *capturer) handleDir(dirname hugofs.FileMetaInfo) error {
 
 		f, active, err := c.newFileInfo(fi.(hugofs.FileMetaInfo), currentType)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		if !active {
@@ -434,11 +552,12 @@ func (c *capturer) handleDir(dirname hugofs.FileMetaInfo) error {
 				// TODO(bep) mod check
 
 				if err := c.handleNestedDir(fi.FileInfo()); err != nil {
-					return err
+					return nil, err
 				}
 			} else if bundleType == bundleNot || (!fi.isOwner() && fi.isContentFile()) {
 				// Not in a bundle.
 				c.copyOrHandleSingle(fi)
+				entry.singles = append(entry.singles, fi)
 			} else {
 				// This is a section folder or similar with non-content files in it.
 				todo = append(todo, fi)
@@ -449,24 +568,117 @@ func (c *capturer) handleDir(dirname hugofs.FileMetaInfo) error {
 	}
 
 	if len(todo) == 0 {
-		return nil
+		return entry, nil
 	}
 
 	dirs, err := c.createBundleDirs(todo, bundleType)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Send the bundle to the next step in the processor chain.
 	c.handler.handleBundles(dirs)
+	entry.bundle = dirs
+
+	return entry, nil
+}
+
+// handleNonBundleCached is a cache-aware wrapper around handleNonBundle for
+// plain (non-bundle) directories: assets-only folders we just copy, and
+// section-like folders with no bundle resources. If dirname's direct
+// entries hash the same as they did the last time this ran in this
+// process, the previous handler dispatches are replayed instead of
+// re-opening and reclassifying every file. Subdirectories are always
+// walked regardless (handleNestedDir is itself cache-aware for its own
+// level), since this hash only covers dirname's direct entries.
+func (c *capturer) handleNonBundleCached(dirname hugofs.FileMetaInfo, files []os.FileInfo, singlesOnly bool) error {
+	realPath := dirname.Meta().Filename()
+
+	hash, hashErr := c.cache.hashEntries(files, c.hashFile)
+	if hashErr == nil {
+		if ops, ok := c.cache.lookup(realPath, hash); ok {
+			for _, op := range ops {
+				if op.single != nil {
+					c.handler.handleSingles(op.single)
+				} else {
+					c.handler.handleCopyFile(op.copyMeta)
+				}
+			}
+			return c.handleNonBundleDirs(files)
+		}
+	}
+
+	rec := &dirOpRecorder{}
+	if err := c.handleNonBundle(dirname, files, singlesOnly, rec); err != nil {
+		return err
+	}
+
+	if hashErr == nil {
+		c.cache.store(realPath, hash, rec.ops)
+	}
 
 	return nil
 }
 
+// hashFile returns fi's content hash, consulting/populating the capturer's
+// captureCache. For a file served by a non-local SourceBackend, the
+// backend's Etag is hashed instead of its content: that's cheaper (no need
+// to stream a remote/archived file just to invalidate a cache), and is the
+// only stable signal some backends can offer at all.
+func (c *capturer) hashFile(fi os.FileInfo) (uint64, error) {
+	fim := fi.(hugofs.FileMetaInfo)
+	meta := fim.Meta()
+	realPath := meta.Filename()
+
+	if lbfs, ok := c.fs.(*layeredBackendFs); ok {
+		if b, found := lbfs.backendFor(realPath); found && !b.IsLocal() {
+			etag, err := b.Etag(realPath)
+			if err != nil {
+				return 0, errors.Wrapf(err, "hashFile: failed to get etag for %q", realPath)
+			}
+			return xxhash.Sum64String(etag), nil
+		}
+	}
+
+	entry, err := c.cache.fileHash(realPath, fi, meta.Open)
+	if err != nil {
+		return 0, err
+	}
+	return entry.Hash, nil
+}
+
+// hashDir computes fi's content hash recursively: used by
+// handleBundleDirCached for leaf bundles, where a subdirectory's files are
+// folded wholesale into the parent bundle, so a change anywhere below fi
+// must be observed, not just its own name and mtime.
+func (c *capturer) hashDir(fi os.FileInfo) (uint64, error) {
+	fim := fi.(hugofs.FileMetaInfo)
+	children, err := c.readDir(fim)
+	if err != nil {
+		return 0, err
+	}
+	return c.cache.hashEntriesDeep(children, c.hashFile, c.hashDir)
+}
+
+// handleNonBundleDirs walks the subdirectories in files, leaving the files
+// themselves untouched -- used to replay a non-bundle directory's nested
+// dirs on a cache hit.
+func (c *capturer) handleNonBundleDirs(files []os.FileInfo) error {
+	for _, fi := range files {
+		if fi.IsDir() {
+			if err := c.handleNestedDir(fi.(hugofs.FileMetaInfo)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (c *capturer) handleNonBundle(
 	dirname hugofs.FileMetaInfo,
 	fileInfos []os.FileInfo,
-	singlesOnly bool) error {
+	singlesOnly bool,
+	rec *dirOpRecorder) error {
 
 	for _, fi := range fileInfos {
 		fim := fi.(hugofs.FileMetaInfo)
@@ -486,8 +698,10 @@ func (c *capturer) handleNonBundle(
 					continue
 				}
 				c.handler.handleSingles(f)
+				rec.recordSingle(f)
 			} else {
 				c.handler.handleCopyFile(fim.Meta())
+				rec.recordCopyFile(fim.Meta())
 			}
 		}
 	}
@@ -736,11 +950,28 @@ func (c *capturer) resolveRealPath(path string) (hugofs.FileMetaInfo, error) {
 	return fileInfo, c.resolveRealPathIn(fileInfo)
 }
 
+// isLocalPath reports whether path is served by the real, local filesystem.
+// It's false only when path is owned by a SourceBackend that reports
+// IsLocal() == false, in which case resolveRealPathIn has nothing to do:
+// non-local backends (git, tar, HTTP) never produce real OS symlinks.
+func (c *capturer) isLocalPath(path string) bool {
+	lbfs, ok := c.fs.(*layeredBackendFs)
+	if !ok {
+		return true
+	}
+	b, found := lbfs.backendFor(path)
+	return !found || b.IsLocal()
+}
+
 func (c *capturer) resolveRealPathIn(fileInfo hugofs.FileMetaInfo) error {
 
 	basePath := "" // TODO(bep) mod fileInfo.BaseDir()
 	path := fileInfo.Meta().Filename()
 
+	if !c.isLocalPath(path) {
+		return nil
+	}
+
 	realPath := path
 
 	if fileInfo.Mode()&os.ModeSymlink == os.ModeSymlink {
@@ -18,7 +18,9 @@ import (
 	"fmt"
 	"path/filepath"
 	"sort"
+	"sync"
 
+	"github.com/gohugoio/hugo/config"
 	"github.com/gohugoio/hugo/resources"
 
 	"github.com/pkg/errors"
@@ -46,8 +48,6 @@ const (
 )
 
 func newPagesCollector(sp *source.SourceSpec, logger *loggers.Logger, proc pagesCollectorProcessorProvider) *pagesCollector {
-	//numWorkers := config.GetNumWorkerMultiplier() * 3
-
 	return &pagesCollector{
 		fs:     sp.SourceFs,
 		proc:   proc,
@@ -62,10 +62,9 @@ type fileinfoBundle struct {
 }
 
 type pagesCollector struct {
-	sp         *source.SourceSpec
-	fs         afero.Fs
-	logger     *loggers.Logger
-	numWorkers int
+	sp     *source.SourceSpec
+	fs     afero.Fs
+	logger *loggers.Logger
 
 	proc pagesCollectorProcessorProvider
 }
@@ -137,6 +136,7 @@ func (c *pagesCollector) Collect() error {
 
 	w := hugofs.NewWalkway(hugofs.WalkwayConfig{
 		Fs:      c.fs,
+		Logger:  c.logger,
 		HookPre: preHook,
 		WalkFn:  wfn})
 
@@ -241,7 +241,12 @@ func (c *pagesCollector) handleBundleBranch(readdir []hugofs.FileMetaInfo) error
 		c.addToBundle(fim, bundles)
 	}
 
-	return c.proc.Process(bundles)
+	// Unlike a leaf bundle or a plain file, a branch bundle header's page
+	// must exist on the Site before the walk goes on to enqueue any work
+	// for the child directories it's about to descend into -- so this has
+	// to be processed synchronously here, not handed off to the worker
+	// pool. See pagesProcessor.ProcessSync.
+	return c.proc.ProcessSync(bundles)
 
 }
 
@@ -272,6 +277,7 @@ func (c *pagesCollector) handleBundleLeaf(dir hugofs.FileMetaInfo, path string,
 		Fs:         c.fs,
 		Info:       dir,
 		DirEntries: readdir,
+		Logger:     c.logger,
 		WalkFn:     walk})
 
 	if err := w.Walk(); err != nil {
@@ -314,16 +320,19 @@ func (c *pagesCollector) sortBundleDir(fis []hugofs.FileMetaInfo) {
 type pagesCollectorProcessorProvider interface {
 	Close()
 	Process(item interface{}) error
+	ProcessSync(item interface{}) error
 	Start(ctx context.Context) context.Context
 	Wait() error
 }
 
 func newPagesProcessor(h *HugoSites, sp *source.SourceSpec, partialBuild bool) *pagesProcessor {
+	numWorkers := config.GetNumWorkerMultiplier() * 3
+
 	return &pagesProcessor{
 		h:            h,
 		sp:           sp,
 		partialBuild: partialBuild,
-		pagesChan:    make(chan *pageState, 4),
+		numWorkers:   numWorkers,
 	}
 }
 
@@ -331,16 +340,29 @@ type pagesProcessor struct {
 	h  *HugoSites
 	sp *source.SourceSpec
 
-	// The output Pages
+	// Directory-level work items (bundles or single files) handed off by the
+	// collector's walker. Buffered numWorkers deep, so the walker can run
+	// that many items ahead of the workers before Process blocks.
+	itemsChan chan interface{}
+
+	// The output Pages, consumed by a single goroutine that adds/replaces
+	// them on their Site, guarded by pagesMu.
 	pagesChan chan *pageState
 
+	// Guards addPage/replacePage, the Site mutation at the end of a Page's
+	// life cycle here: the async consumer of pagesChan and ProcessSync's
+	// synchronous callers (branch bundle headers) can both reach it.
+	pagesMu sync.Mutex
+
+	numWorkers int
+
 	partialBuild bool // TODO(bep) mod set
 
 	g *errgroup.Group
 }
 
 func (proc *pagesProcessor) Close() {
-	close(proc.pagesChan)
+	close(proc.itemsChan)
 }
 
 func (proc *pagesProcessor) sendError(err error) {
@@ -350,7 +372,65 @@ func (proc *pagesProcessor) sendError(err error) {
 	proc.h.SendError(err)
 }
 
+// Process hands an item (a leaf bundle or a single file) off to the worker
+// pool for asynchronous processing. It's safe to call concurrently, but
+// workers race to drain itemsChan, so nothing guarantees the resulting
+// Page(s) reach addPage/replacePage in enqueue order. That's fine for leaf
+// bundles and plain files, which don't depend on anything else in their
+// directory, but it is NOT fine for a branch bundle header, which has to
+// exist on the Site before any of its child directories' items are even
+// enqueued -- see ProcessSync, used for that case instead.
 func (proc *pagesProcessor) Process(item interface{}) error {
+	proc.itemsChan <- item
+	return nil
+}
+
+// ProcessSync builds and adds/replaces the page(s) for item immediately, on
+// the calling goroutine, bypassing itemsChan/pagesChan entirely. The
+// collector's walker uses this for a branch bundle's header+siblings
+// (instead of Process) so that, by the time it returns and the walk goes on
+// to descend into that branch's child directories, the branch page is
+// already visible on the Site -- addOrReplace shares pagesMu with the
+// pagesChan consumer below, so this can't race it.
+func (proc *pagesProcessor) ProcessSync(item interface{}) error {
+	switch v := item.(type) {
+	case map[string]*fileinfoBundle:
+		for _, bundle := range v {
+			p, err := proc.newPageFromBundle(bundle)
+			if err != nil {
+				proc.sendError(err)
+				continue
+			}
+			proc.addOrReplace(p)
+		}
+	default:
+		panic(fmt.Sprintf("ProcessSync: unsupported item type %T", item))
+	}
+	return nil
+}
+
+// addOrReplace adds p to its Site's page collection, or replaces the
+// existing one if this is a partial (server-mode) rebuild. Guarded by
+// pagesMu since both the async pagesChan consumer and ProcessSync's
+// synchronous callers reach it.
+func (proc *pagesProcessor) addOrReplace(p *pageState) {
+	proc.pagesMu.Lock()
+	defer proc.pagesMu.Unlock()
+
+	s := p.s
+	p.forceRender = proc.partialBuild
+
+	if p.forceRender {
+		s.replacePage(p)
+	} else {
+		s.addPage(p)
+	}
+}
+
+// process builds the Page(s)/Resource(s) for a single work item. It's called
+// concurrently by the worker pool spawned in startProcessor, so it must not
+// touch anything that isn't safe for concurrent use (e.g. Site.Pages).
+func (proc *pagesProcessor) process(item interface{}) error {
 	send := func(p *pageState, err error) {
 		if err != nil {
 			proc.sendError(err)
@@ -513,19 +593,42 @@ func (proc *pagesProcessor) getSite(lang string) *Site {
 	return proc.h.Sites[0]
 }
 
+// startProcessor spins up the worker pool that turns directory-level work
+// items into Pages/Resources, plus the single goroutine that owns adding the
+// finished Pages to their Site. Errors from any worker are propagated through
+// the returned errgroup and cancel its context; the collector's walker does
+// not observe that context.
 func (proc *pagesProcessor) startProcessor(ctx context.Context) (*errgroup.Group, context.Context) {
-	proc.pagesChan = make(chan *pageState, 4)
+	proc.itemsChan = make(chan interface{}, proc.numWorkers)
+	proc.pagesChan = make(chan *pageState, proc.numWorkers)
+
 	g, ctx := errgroup.WithContext(ctx)
-	g.Go(func() error {
-		for p := range proc.pagesChan {
-			s := p.s
-			p.forceRender = proc.partialBuild
 
-			if p.forceRender {
-				s.replacePage(p)
-			} else {
-				s.addPage(p)
+	var workersWg sync.WaitGroup
+	workersWg.Add(proc.numWorkers)
+
+	for i := 0; i < proc.numWorkers; i++ {
+		g.Go(func() error {
+			defer workersWg.Done()
+			for item := range proc.itemsChan {
+				if err := proc.process(item); err != nil {
+					return err
+				}
 			}
+			return nil
+		})
+	}
+
+	// Once every worker is done producing Pages, it's safe to close
+	// pagesChan and let the consumer below drain and return.
+	go func() {
+		workersWg.Wait()
+		close(proc.pagesChan)
+	}()
+
+	g.Go(func() error {
+		for p := range proc.pagesChan {
+			proc.addOrReplace(p)
 		}
 		return nil
 	})
@@ -0,0 +1,65 @@
+// Copyright 2019 The Hugo Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hugolib
+
+import (
+	"fmt"
+	"testing"
+)
+
+// BenchmarkPagesCollectorCold measures cold-build time for a synthetic
+// multilingual site, exercising the pagesCollector/pagesProcessor worker pool
+// added for the parallel collection rewrite.
+func BenchmarkPagesCollectorCold(b *testing.B) {
+	const (
+		numPages     = 5000
+		numLanguages = 2
+	)
+
+	newBuilder := func() *sitesBuilder {
+		builder := newTestSitesBuilder(b).WithConfigFile("toml", `
+baseURL = "https://example.com"
+defaultContentLanguage = "en"
+
+[languages]
+[languages.en]
+weight = 1
+[languages.nn]
+weight = 2
+`)
+
+		for i := 0; i < numPages; i++ {
+			content := fmt.Sprintf(`---
+title: "Page %d"
+---
+Content for page %d.
+`, i, i)
+			builder.WithContent(fmt.Sprintf("content/blog/page%d/index.en.md", i), content)
+			builder.WithContent(fmt.Sprintf("content/blog/page%d/index.nn.md", i), content)
+			builder.WithContent(fmt.Sprintf("content/blog/page%d/data.json", i), `{"k": "v"}`)
+		}
+
+		return builder
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		builder := newBuilder()
+		b.StartTimer()
+
+		builder.Build(BuildCfg{})
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2019 The Hugo Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hugolib
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// SourceBackend is a read-only source of content for the capturer, in
+// addition to (or instead of) a plain afero.Fs rooted on disk, e.g. a git
+// tree pinned at a ref, a tar/zip archive streamed into memory, or an
+// HTTP-mounted directory listing.
+//
+// A SourceBackend is itself an afero.Fs (Stat/Open/Readdir via the returned
+// afero.File), so it composes naturally with the rest of the hugofs
+// decorator chain; newLayeredBackendFs is what capturer.fs is set to once
+// one or more backends are in play.
+type SourceBackend interface {
+	afero.Fs
+
+	// IsLocal reports whether this backend serves paths that live on the
+	// real, local filesystem. Only local backends can have real OS
+	// symlinks, so capturer's symlink-cycle detection (resolveRealPathIn)
+	// skips filepath.EvalSymlinks/os.Stat entirely for the rest.
+	IsLocal() bool
+
+	// Etag returns an identifier for the content at name that changes iff
+	// the content does -- a git blob hash, an archive entry's checksum, an
+	// HTTP ETag response header -- so captureCache can key the incremental
+	// cache off it without having to read (or, for some backends, even
+	// being able to cheaply re-read) the full file content.
+	Etag(name string) (string, error)
+}
+
+// newLayeredBackendFs composes backends into a single read-only afero.Fs,
+// with earlier backends shadowing later ones for paths they both serve,
+// e.g. mounting a theme from git ahead of content from a tar archive.
+func newLayeredBackendFs(backends []SourceBackend) *layeredBackendFs {
+	return &layeredBackendFs{backends: backends}
+}
+
+// localSourceBackend adapts a plain, local afero.Fs (the project's own
+// sourceSpec.SourceFs) to SourceBackend, so it can be layered alongside git/
+// tar/HTTP backends instead of being displaced by them: newCapturer appends
+// one as the last, lowest-priority backend whenever extra backends are in
+// play, making sure the project's own content is still reachable.
+type localSourceBackend struct {
+	afero.Fs
+}
+
+func newLocalSourceBackend(fs afero.Fs) *localSourceBackend {
+	return &localSourceBackend{Fs: fs}
+}
+
+func (b *localSourceBackend) IsLocal() bool { return true }
+
+func (b *localSourceBackend) Etag(name string) (string, error) {
+	return "", errors.New("localSourceBackend: Etag is not supported, this backend is local")
+}
+
+type layeredBackendFs struct {
+	backends []SourceBackend
+}
+
+// backendFor returns the first backend (in priority order) that has name.
+func (fs *layeredBackendFs) backendFor(name string) (SourceBackend, bool) {
+	for _, b := range fs.backends {
+		if _, err := b.Stat(name); err == nil {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+func (fs *layeredBackendFs) Name() string { return "layeredBackendFs" }
+
+func (fs *layeredBackendFs) Stat(name string) (os.FileInfo, error) {
+	b, ok := fs.backendFor(name)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return b.Stat(name)
+}
+
+func (fs *layeredBackendFs) Open(name string) (afero.File, error) {
+	b, ok := fs.backendFor(name)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return b.Open(name)
+}
+
+var errReadOnlyBackendFs = errors.New("layeredBackendFs is read-only")
+
+func (fs *layeredBackendFs) Create(name string) (afero.File, error)    { return nil, errReadOnlyBackendFs }
+func (fs *layeredBackendFs) Mkdir(name string, perm os.FileMode) error { return errReadOnlyBackendFs }
+func (fs *layeredBackendFs) MkdirAll(path string, perm os.FileMode) error {
+	return errReadOnlyBackendFs
+}
+func (fs *layeredBackendFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	return nil, errReadOnlyBackendFs
+}
+func (fs *layeredBackendFs) Remove(name string) error                  { return errReadOnlyBackendFs }
+func (fs *layeredBackendFs) RemoveAll(path string) error               { return errReadOnlyBackendFs }
+func (fs *layeredBackendFs) Rename(oldname, newname string) error      { return errReadOnlyBackendFs }
+func (fs *layeredBackendFs) Chmod(name string, mode os.FileMode) error { return errReadOnlyBackendFs }
+func (fs *layeredBackendFs) Chown(name string, uid, gid int) error     { return errReadOnlyBackendFs }
+func (fs *layeredBackendFs) Chtimes(name string, atime, mtime time.Time) error {
+	return errReadOnlyBackendFs
+}
@@ -0,0 +1,142 @@
+// Copyright 2019 The Hugo Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hugolib
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gohugoio/hugo/hugofs"
+)
+
+// fakeBackend is a minimal SourceBackend backed by an in-memory afero.Fs,
+// used to test layeredBackendFs and the backend-aware bits of capturer
+// without a real git/tar/HTTP implementation.
+type fakeBackend struct {
+	afero.Fs
+	local bool
+	etag  string
+}
+
+func newFakeBackend(local bool, etag string) *fakeBackend {
+	return &fakeBackend{Fs: afero.NewMemMapFs(), local: local, etag: etag}
+}
+
+func (b *fakeBackend) IsLocal() bool                    { return b.local }
+func (b *fakeBackend) Etag(name string) (string, error) { return b.etag, nil }
+
+func TestLayeredBackendFsShadowOrder(t *testing.T) {
+	assert := require.New(t)
+
+	local := newFakeBackend(true, "")
+	assert.NoError(afero.WriteFile(local, "shared.txt", []byte("from local"), 0666))
+	assert.NoError(afero.WriteFile(local, "local-only.txt", []byte("local-only"), 0666))
+
+	remote := newFakeBackend(false, "etag-v1")
+	assert.NoError(afero.WriteFile(remote, "shared.txt", []byte("from remote"), 0666))
+	assert.NoError(afero.WriteFile(remote, "remote-only.txt", []byte("remote-only"), 0666))
+
+	lbfs := newLayeredBackendFs([]SourceBackend{local, remote})
+
+	// local was listed first, so it shadows remote for a path both serve.
+	f, err := lbfs.Open("shared.txt")
+	assert.NoError(err)
+	b, err := ioutil.ReadAll(f)
+	assert.NoError(err)
+	f.Close()
+	assert.Equal("from local", string(b))
+
+	owner, ok := lbfs.backendFor("shared.txt")
+	assert.True(ok)
+	assert.True(owner == SourceBackend(local))
+
+	// remote-only.txt isn't served by local, so it falls through to remote.
+	_, err = lbfs.Stat("remote-only.txt")
+	assert.NoError(err)
+	owner, ok = lbfs.backendFor("remote-only.txt")
+	assert.True(ok)
+	assert.True(owner == SourceBackend(remote))
+
+	_, err = lbfs.Stat("nowhere.txt")
+	assert.True(os.IsNotExist(err))
+}
+
+func TestLocalSourceBackendReachableAlongsideExtraBackends(t *testing.T) {
+	assert := require.New(t)
+
+	local := newLocalSourceBackend(afero.NewMemMapFs())
+	assert.NoError(afero.WriteFile(local.Fs, "content/local-only.txt", []byte("local"), 0666))
+	assert.True(local.IsLocal())
+	_, err := local.Etag("content/local-only.txt")
+	assert.Error(err)
+
+	extra := newFakeBackend(false, "etag-v1")
+	assert.NoError(afero.WriteFile(extra, "content/extra-only.txt", []byte("extra"), 0666))
+
+	// newCapturer appends the project's own SourceFs as a local backend
+	// last, so it's still reachable for anything the extra backends don't
+	// serve, instead of being displaced by them.
+	lbfs := newLayeredBackendFs([]SourceBackend{extra, local})
+
+	_, err = lbfs.Stat("content/local-only.txt")
+	assert.NoError(err)
+	_, err = lbfs.Stat("content/extra-only.txt")
+	assert.NoError(err)
+}
+
+func TestCapturerHashFileUsesEtagForNonLocalBackend(t *testing.T) {
+	assert := require.New(t)
+
+	local := newFakeBackend(true, "")
+	assert.NoError(afero.WriteFile(local, "local.txt", []byte("hello"), 0666))
+
+	remote := newFakeBackend(false, "etag-v1")
+	assert.NoError(afero.WriteFile(remote, "remote.txt", []byte("world"), 0666))
+
+	lbfs := newLayeredBackendFs([]SourceBackend{local, remote})
+	decorated := hugofs.NewBaseFileDecorator(lbfs)
+
+	dir, err := ioutil.TempDir("", "hugo-capturer-cache")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	c := &capturer{fs: lbfs, cache: newCaptureCache(dir)}
+
+	localFi, err := decorated.Stat("local.txt")
+	assert.NoError(err)
+	assert.True(c.isLocalPath(localFi.(hugofs.FileMetaInfo).Meta().Filename()))
+
+	h1, err := c.hashFile(localFi)
+	assert.NoError(err)
+	assert.NotZero(h1)
+
+	remoteFi, err := decorated.Stat("remote.txt")
+	assert.NoError(err)
+	assert.False(c.isLocalPath(remoteFi.(hugofs.FileMetaInfo).Meta().Filename()))
+
+	// The non-local backend's Etag is hashed directly, not the file content.
+	h2, err := c.hashFile(remoteFi)
+	assert.NoError(err)
+	assert.Equal(xxhash.Sum64String("etag-v1"), h2)
+
+	remote.etag = "etag-v2"
+	h3, err := c.hashFile(remoteFi)
+	assert.NoError(err)
+	assert.NotEqual(h2, h3)
+}